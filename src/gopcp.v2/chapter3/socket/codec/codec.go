@@ -0,0 +1,180 @@
+/*
+Package codec 实现了一种带长度前缀的帧式通信协议，用来取代逐字节扫描分界符的
+读取方式。
+
+帧格式：
+	4字节大端长度前缀（取值为"1字节帧类型标记 + payload"的总字节数）
+	1字节帧类型标记
+	N字节payload
+
+在帧之上使用encoding/gob编码Request/Response消息，使服务端可以在立方根
+之外支持更多运算（如平方根、对数等），客户端也可以依据ID把响应和请求
+对应起来。
+*/
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 帧类型标记。
+const (
+	FrameRequest byte = iota
+	FrameResponse
+	FrameError
+	FramePing
+	FramePong
+)
+
+// DefaultBufferSize 是Encoder/Decoder内部bufio缓冲区的默认大小。
+const DefaultBufferSize = 4 * 1024 // 4 KiB
+
+// DefaultMaxFrameLength 是ReadFrame默认能够接受的最大帧长度（含帧类型标记，
+// 不含长度前缀本身）。超过该长度的帧会被Decoder以ErrFrameTooLarge拒绝，
+// 防止恶意或异常的对端借助超大帧耗尽内存。
+const DefaultMaxFrameLength = 1 << 20 // 1 MiB
+
+// lengthPrefixSize 是长度前缀自身占用的字节数。
+const lengthPrefixSize = 4
+
+// ErrFrameTooLarge 在收到的帧长度超过Decoder允许的最大长度时返回。
+var ErrFrameTooLarge = errors.New("codec: frame exceeds max length")
+
+// Encoder 把帧写入底层io.Writer，内部使用固定大小的bufio.Writer缓冲，
+// 避免WriteFrame的每次调用都触发一次系统调用。
+type Encoder struct {
+	w *bufio.Writer
+}
+
+// NewEncoder 基于w创建一个使用默认缓冲区大小的Encoder。
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: bufio.NewWriterSize(w, DefaultBufferSize)}
+}
+
+// WriteFrame 把frameType和payload编码为一帧数据写入底层连接并立即Flush，
+// 使调用方无需关心缓冲刷新的时机。header和payload被拼接后通过一次Write
+// 调用交给底层bufio.Writer，而不是分两次写入——这样当多个goroutine共享
+// 同一个连接（分别持有各自的Encoder）并依靠外部锁串行化WriteFrame调用时，
+// 不会有另一个goroutine的帧插入到本帧的header和payload之间。
+func (e *Encoder) WriteFrame(frameType byte, payload []byte) error {
+	frame := make([]byte, lengthPrefixSize+1+len(payload))
+	binary.BigEndian.PutUint32(frame[:lengthPrefixSize], uint32(len(payload)+1))
+	frame[lengthPrefixSize] = frameType
+	copy(frame[lengthPrefixSize+1:], payload)
+	if _, err := e.w.Write(frame); err != nil {
+		return fmt.Errorf("codec: write frame: %w", err)
+	}
+	if err := e.w.Flush(); err != nil {
+		return fmt.Errorf("codec: flush frame: %w", err)
+	}
+	return nil
+}
+
+// Decoder 从底层io.Reader中读取帧，内部使用固定大小的bufio.Reader缓冲。
+type Decoder struct {
+	r         *bufio.Reader
+	maxLength uint32
+}
+
+// NewDecoder 基于r创建一个使用默认缓冲区大小和默认最大帧长度的Decoder。
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:         bufio.NewReaderSize(r, DefaultBufferSize),
+		maxLength: DefaultMaxFrameLength,
+	}
+}
+
+// SetMaxFrameLength 设置ReadFrame能够接受的最大帧长度（含帧类型标记，
+// 不含长度前缀本身），用于按需收紧DefaultMaxFrameLength。
+func (d *Decoder) SetMaxFrameLength(n uint32) {
+	d.maxLength = n
+}
+
+// ReadFrame 读取并返回下一帧的帧类型标记和payload。
+// 当底层连接被对端关闭时返回io.EOF，帧超过最大长度时返回ErrFrameTooLarge。
+func (d *Decoder) ReadFrame() (frameType byte, payload []byte, err error) {
+	header := make([]byte, lengthPrefixSize)
+	if _, err = io.ReadFull(d.r, header); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length == 0 {
+		return 0, nil, fmt.Errorf("codec: empty frame")
+	}
+	if length > d.maxLength {
+		return 0, nil, ErrFrameTooLarge
+	}
+	body := make([]byte, length)
+	if _, err = io.ReadFull(d.r, body); err != nil {
+		return 0, nil, err
+	}
+	return body[0], body[1:], nil
+}
+
+// Op 标识服务端支持的运算种类。
+type Op string
+
+// 服务端当前支持的运算。
+const (
+	OpCbrt Op = "cbrt"
+	OpSqrt Op = "sqrt"
+	OpLog  Op = "log"
+)
+
+// Request 是经由gob编码、携带在FrameRequest帧payload中的请求消息。
+// ID由客户端分配，用于在收到响应时匹配发出的请求。
+type Request struct {
+	ID    uint64
+	Op    Op
+	Value int32
+}
+
+// Response 是经由gob编码、携带在FrameResponse帧payload中的响应消息。
+// 当ErrMsg非空时，表示该请求处理失败，Result无意义。
+type Response struct {
+	ID     uint64
+	Result float64
+	ErrMsg string
+}
+
+// EncodeRequest 把req编码为gob字节流，供Encoder.WriteFrame(FrameRequest, ...)使用。
+func EncodeRequest(req Request) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(req); err != nil {
+		return nil, fmt.Errorf("codec: encode request: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeRequest 把FrameRequest帧的payload解码为Request。
+func DecodeRequest(payload []byte) (Request, error) {
+	var req Request
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&req); err != nil {
+		return Request{}, fmt.Errorf("codec: decode request: %w", err)
+	}
+	return req, nil
+}
+
+// EncodeResponse 把resp编码为gob字节流，供Encoder.WriteFrame(FrameResponse, ...)使用。
+func EncodeResponse(resp Response) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(resp); err != nil {
+		return nil, fmt.Errorf("codec: encode response: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeResponse 把FrameResponse帧的payload解码为Response。
+func DecodeResponse(payload []byte) (Response, error) {
+	var resp Response
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&resp); err != nil {
+		return Response{}, fmt.Errorf("codec: decode response: %w", err)
+	}
+	return resp, nil
+}
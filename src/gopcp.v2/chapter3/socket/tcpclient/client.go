@@ -0,0 +1,467 @@
+/*
+Package tcpclient 提供一个长连接、可自动重连的TCP客户端，设计上参照了
+net/rpc的Client：每个Call被分配一个唯一递增的ID，通过单独的读goroutine
+对响应进行解复用，从而使多个Call可以在同一条连接上并发流水线执行。
+*/
+package tcpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopcp.v2/chapter3/socket/codec"
+)
+
+// ErrClosed在Client已经Close后继续调用Call/Ping时返回。
+var ErrClosed = errors.New("tcpclient: client is closed")
+
+// DefaultMaxInFlight是MaxInFlight未通过WithMaxInFlight定制时允许的最大
+// 并发Call数量。
+const DefaultMaxInFlight = 256
+
+// 默认的重连退避参数。
+const (
+	defaultBackoffBase = 200 * time.Millisecond
+	defaultBackoffMax  = 10 * time.Second
+)
+
+// Option定制Dial创建的Client的可选行为。
+type Option func(*Client)
+
+// WithMaxInFlight限制同时等待响应的Call数量，超过时Call会阻塞直到有名额
+// 释放，避免pending表在对端响应缓慢时无限增长占用内存。
+func WithMaxInFlight(n int) Option {
+	return func(c *Client) { c.maxInFlight = n }
+}
+
+// WithKeepalive让Client每隔period向服务端发送一个FramePing帧，并在连接
+// 超过idleTimeout没有收到任何数据帧或FramePong时认为连接已经失联，主动
+// 断开并触发重连。idleTimeout<=0表示只发送心跳、不做闲置检测。
+func WithKeepalive(period, idleTimeout time.Duration) Option {
+	return func(c *Client) {
+		c.keepalivePeriod = period
+		c.idleTimeout = idleTimeout
+	}
+}
+
+// WithBackoff定制连接断开后的指数退避重连参数，默认[200ms, 10s]并带抖动。
+func WithBackoff(base, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.backoffBase = base
+		c.backoffMax = maxBackoff
+	}
+}
+
+// callResult是readLoop解复用出的一次Call的最终结果。
+type callResult struct {
+	resp codec.Response
+	err  error
+}
+
+// Client是一个可重用于多个并发Call的长连接客户端。应使用Dial创建，
+// 而不是直接构造其零值。
+type Client struct {
+	network, addr string
+
+	maxInFlight     int
+	keepalivePeriod time.Duration
+	idleTimeout     time.Duration
+	backoffBase     time.Duration
+	backoffMax      time.Duration
+
+	sem chan struct{} // 节流：同时在途的Call数量不超过maxInFlight
+
+	mu           sync.Mutex // 保护conn/encoder/decoder/pending/lastActivity，重连时整体替换
+	conn         net.Conn
+	encoder      *codec.Encoder
+	decoder      *codec.Decoder
+	pending      map[uint64]chan callResult
+	pingWaiters  []chan error
+	lastActivity time.Time
+
+	writeMu sync.Mutex // 串行化对encoder的写入，Call/Ping/心跳可能并发发帧
+
+	nextID uint64 // atomic
+
+	closed  int32 // atomic，0表示仍然打开
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// Dial建立到network/addr的连接并返回一个可立即使用的Client。
+func Dial(ctx context.Context, network, addr string, opts ...Option) (*Client, error) {
+	c := &Client{
+		network:     network,
+		addr:        addr,
+		maxInFlight: DefaultMaxInFlight,
+		backoffBase: defaultBackoffBase,
+		backoffMax:  defaultBackoffMax,
+		pending:     make(map[uint64]chan callResult),
+		closeCh:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.maxInFlight <= 0 {
+		c.maxInFlight = DefaultMaxInFlight
+	}
+	c.sem = make(chan struct{}, c.maxInFlight)
+
+	if err := c.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	c.wg.Add(1)
+	go c.readLoop()
+	if c.keepalivePeriod > 0 {
+		c.wg.Add(1)
+		go c.heartbeatLoop()
+	}
+	return c, nil
+}
+
+// connect拨号并原子性地替换conn/encoder/decoder，供Dial和重连共用。
+func (c *Client) connect(ctx context.Context) error {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, c.network, c.addr)
+	if err != nil {
+		return fmt.Errorf("tcpclient: dial error: %w", err)
+	}
+
+	c.mu.Lock()
+	c.conn = conn
+	c.encoder = codec.NewEncoder(conn)
+	c.decoder = codec.NewDecoder(conn)
+	c.lastActivity = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// currentIO返回当前连接上的encoder/decoder，重连后readLoop和heartbeatLoop
+// 需要通过它感知到替换后的新连接。
+func (c *Client) currentIO() (*codec.Encoder, *codec.Decoder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.encoder, c.decoder
+}
+
+func (c *Client) touch() {
+	c.mu.Lock()
+	c.lastActivity = time.Now()
+	c.mu.Unlock()
+}
+
+func (c *Client) idleSince() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Since(c.lastActivity)
+}
+
+func (c *Client) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) != 0
+}
+
+// Call发送一个op/value请求并阻塞等待匹配的响应，可与其他Call并发调用，
+// 它们会流水线式地共享同一条连接。
+func (c *Client) Call(ctx context.Context, op string, value int32) (float64, error) {
+	if c.isClosed() {
+		return 0, ErrClosed
+	}
+
+	select {
+	case c.sem <- struct{}{}:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	defer func() { <-c.sem }()
+
+	id := atomic.AddUint64(&c.nextID, 1)
+	resultCh := make(chan callResult, 1)
+
+	c.mu.Lock()
+	if c.isClosed() {
+		c.mu.Unlock()
+		return 0, ErrClosed
+	}
+	c.pending[id] = resultCh
+	encoder := c.encoder
+	c.mu.Unlock()
+
+	body, err := codec.EncodeRequest(codec.Request{ID: id, Op: codec.Op(op), Value: value})
+	if err != nil {
+		c.removePending(id)
+		return 0, fmt.Errorf("tcpclient: encode request: %w", err)
+	}
+
+	c.writeMu.Lock()
+	err = encoder.WriteFrame(codec.FrameRequest, body)
+	c.writeMu.Unlock()
+	if err != nil {
+		c.removePending(id)
+		return 0, fmt.Errorf("tcpclient: write request: %w", err)
+	}
+
+	select {
+	case res := <-resultCh:
+		if res.err != nil {
+			return 0, res.err
+		}
+		if res.resp.ErrMsg != "" {
+			return 0, errors.New(res.resp.ErrMsg)
+		}
+		return res.resp.Result, nil
+	case <-ctx.Done():
+		c.removePending(id)
+		return 0, ctx.Err()
+	case <-c.closeCh:
+		return 0, ErrClosed
+	}
+}
+
+func (c *Client) removePending(id uint64) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+// Ping发送一个FramePing帧并等待服务端的FramePong，返回往返耗时，使应用
+// 可以独立于请求流量探测连接的存活情况。
+//
+// codec的心跳帧不携带ID，Pong只能按发出顺序和Ping配对：heartbeatLoop发出
+// 的周期性FramePing也会通过enqueuePingWaiter登记一个占位等待者，使得
+// Ping()和后台心跳共享同一条FIFO队列，收到的Pong始终和发出时那一次Ping
+// 对应，不会被交叉匹配到无关的心跳探测上。登记等待者和写出FramePing必须
+// 在同一段writeMu临界区内完成，否则两个并发的Ping/心跳可能先登记后写、
+// 却以不同的顺序争到writeMu，使队列顺序和帧在连线上的实际发出顺序不一致，
+// notifyPong按队首配对时就会把Pong错发给无关的调用方。若写FramePing失败、
+// ctx被取消或Client在等待期间Close/重连，都会把本次登记的等待者从队列中
+// 移除，避免它一直占着队首、把后续一次真正的Pong错配给早已返回的调用方。
+func (c *Client) Ping(ctx context.Context) (time.Duration, error) {
+	if c.isClosed() {
+		return 0, ErrClosed
+	}
+
+	encoder, _ := c.currentIO()
+	waitCh := make(chan error, 1)
+
+	start := time.Now()
+	c.writeMu.Lock()
+	c.enqueuePingWaiter(waitCh)
+	err := encoder.WriteFrame(codec.FramePing, nil)
+	c.writeMu.Unlock()
+	if err != nil {
+		c.removePingWaiter(waitCh)
+		return 0, fmt.Errorf("tcpclient: write ping: %w", err)
+	}
+
+	select {
+	case err := <-waitCh:
+		if err != nil {
+			return 0, err
+		}
+		return time.Since(start), nil
+	case <-ctx.Done():
+		c.removePingWaiter(waitCh)
+		return 0, ctx.Err()
+	case <-c.closeCh:
+		c.removePingWaiter(waitCh)
+		return 0, ErrClosed
+	}
+}
+
+// enqueuePingWaiter在发送FramePing之前把ch登记到pingWaiters队尾，使
+// notifyPong能按FIFO顺序把收到的Pong和发出的Ping一一配对。ch为nil表示
+// heartbeatLoop发出的占位等待者——没有调用方在等待它的结果，notifyPong
+// 只需要把它从队列中移走、让位给真正的等待者即可。
+func (c *Client) enqueuePingWaiter(ch chan error) {
+	c.mu.Lock()
+	c.pingWaiters = append(c.pingWaiters, ch)
+	c.mu.Unlock()
+}
+
+// removePingWaiter把ch从pingWaiters队列中移除，用于Ping()自己的Write失败
+// 或等待被ctx/Close提前打断的场景，防止一个再也没人读取的等待者永久滞留
+// 在队列里，偷走后面一次真正Ping的Pong。
+func (c *Client) removePingWaiter(ch chan error) {
+	c.mu.Lock()
+	for i, w := range c.pingWaiters {
+		if w == ch {
+			c.pingWaiters = append(c.pingWaiters[:i], c.pingWaiters[i+1:]...)
+			break
+		}
+	}
+	c.mu.Unlock()
+}
+
+// notifyPong在收到FramePong时被readLoop调用，唤醒最早的一个Ping等待者。
+func (c *Client) notifyPong() {
+	c.mu.Lock()
+	var waiter chan error
+	if len(c.pingWaiters) > 0 {
+		waiter = c.pingWaiters[0]
+		c.pingWaiters = c.pingWaiters[1:]
+	}
+	c.mu.Unlock()
+	if waiter != nil {
+		waiter <- nil
+	}
+}
+
+// readLoop是唯一从连接读取数据的goroutine，负责把响应帧按ID分发给对应
+// 的Call，并在读错误时驱动重连。
+func (c *Client) readLoop() {
+	defer c.wg.Done()
+	for {
+		_, decoder := c.currentIO()
+		frameType, payload, err := decoder.ReadFrame()
+		if err != nil {
+			c.failPending(fmt.Errorf("tcpclient: connection lost: %w", err))
+			if c.isClosed() {
+				return
+			}
+			if !c.reconnectWithBackoff() {
+				return
+			}
+			continue
+		}
+
+		c.touch()
+		switch frameType {
+		case codec.FrameResponse:
+			resp, err := codec.DecodeResponse(payload)
+			if err != nil {
+				continue
+			}
+			c.deliver(resp)
+		case codec.FramePong:
+			c.notifyPong()
+		case codec.FramePing:
+			// 对端（通常是配置了KeepalivePeriod的tcpserver.Server）在探测本
+			// 端是否存活，必须应答FramePong，否则一条没有Call在途的空闲
+			// 连接会被对端误判为失联而关闭。
+			encoder, _ := c.currentIO()
+			c.writeMu.Lock()
+			_ = encoder.WriteFrame(codec.FramePong, nil)
+			c.writeMu.Unlock()
+		}
+	}
+}
+
+func (c *Client) deliver(resp codec.Response) {
+	c.mu.Lock()
+	ch, ok := c.pending[resp.ID]
+	if ok {
+		delete(c.pending, resp.ID)
+	}
+	c.mu.Unlock()
+	if ok {
+		ch <- callResult{resp: resp}
+	}
+}
+
+// failPending让所有仍在等待响应的Call、以及仍在等待Pong的Ping立即以err
+// 失败，用在连接断开、Client关闭的场景——旧连接上的Pong永远不会再到达，
+// 必须清空pingWaiters，否则这些等待者会一直滞留到下一条连接上，错配给
+// 和它们无关的新Ping/心跳。
+func (c *Client) failPending(err error) {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[uint64]chan callResult)
+	waiters := c.pingWaiters
+	c.pingWaiters = nil
+	c.mu.Unlock()
+	for _, ch := range pending {
+		ch <- callResult{err: err}
+	}
+	for _, ch := range waiters {
+		if ch != nil {
+			ch <- err
+		}
+	}
+}
+
+// reconnectWithBackoff在连接断开后按指数退避（带抖动、有上限）持续重试，
+// 直到重连成功或Client被Close。
+func (c *Client) reconnectWithBackoff() bool {
+	backoff := c.backoffBase
+	for {
+		select {
+		case <-c.closeCh:
+			return false
+		case <-time.After(jitter(backoff)):
+		}
+
+		if err := c.connect(context.Background()); err == nil {
+			return true
+		}
+
+		backoff *= 2
+		if backoff > c.backoffMax {
+			backoff = c.backoffMax
+		}
+	}
+}
+
+// jitter把d打散到[d/2, d]之间，避免大量Client在同一时刻集中重连。
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// heartbeatLoop每隔KeepalivePeriod发送一个FramePing帧，并在连接超过
+// IdleTimeout没有任何活动时主动关闭当前连接以触发readLoop重连。
+func (c *Client) heartbeatLoop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.keepalivePeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closeCh:
+			return
+		case <-ticker.C:
+			if c.idleTimeout > 0 && c.idleSince() > c.idleTimeout {
+				c.mu.Lock()
+				conn := c.conn
+				c.mu.Unlock()
+				if conn != nil {
+					conn.Close()
+				}
+				continue
+			}
+			encoder, _ := c.currentIO()
+			c.writeMu.Lock()
+			c.enqueuePingWaiter(nil)
+			_ = encoder.WriteFrame(codec.FramePing, nil)
+			c.writeMu.Unlock()
+		}
+	}
+}
+
+// Close关闭底层连接并使所有挂起和后续的Call/Ping立即失败。
+func (c *Client) Close() error {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return nil
+	}
+	close(c.closeCh)
+
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	var err error
+	if conn != nil {
+		err = conn.Close()
+	}
+
+	c.failPending(ErrClosed)
+	c.wg.Wait()
+	return err
+}
@@ -0,0 +1,259 @@
+package tcpclient
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"gopcp.v2/chapter3/socket/codec"
+	"gopcp.v2/chapter3/socket/tcpserver"
+)
+
+// startTestServer启动一个监听在"127.0.0.1:0"上的tcpserver.Server，并在
+// 测试结束时关闭它。
+func startTestServer(t *testing.T, configure func(*tcpserver.Server)) *tcpserver.Server {
+	t.Helper()
+	s := tcpserver.NewServer("tcp", "127.0.0.1:0", tcpserver.CubeRootHandler{})
+	if configure != nil {
+		configure(s)
+	}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.ListenAndServe() }()
+
+	deadline := time.Now().Add(time.Second)
+	for s.ListenAddr() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start listening in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+		<-errCh
+	})
+	return s
+}
+
+func TestClientCallRoundTrip(t *testing.T) {
+	s := startTestServer(t, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c, err := Dial(ctx, "tcp", s.ListenAddr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	result, err := c.Call(ctx, string(codec.OpCbrt), 27)
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	if result != 3 {
+		t.Fatalf("result = %v, want 3", result)
+	}
+}
+
+func TestClientConcurrentCallsAndClose(t *testing.T) {
+	s := startTestServer(t, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, "tcp", s.ListenAddr().String(), WithMaxInFlight(32))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(v int32) {
+			defer wg.Done()
+			// Close可能和Call并发发生，这里只要求不panic、不data race，
+			// 不要求每次调用都成功。
+			c.Call(ctx, string(codec.OpCbrt), v)
+		}(int32(i + 1))
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	wg.Wait()
+
+	if _, err := c.Call(ctx, string(codec.OpCbrt), 1); err != ErrClosed {
+		t.Fatalf("Call after Close err = %v, want ErrClosed", err)
+	}
+}
+
+// TestClientReconnectWithBackoff验证连接被对端强制关闭后，Client能够自动
+// 重连，后续Call仍然可以成功完成。
+func TestClientReconnectWithBackoff(t *testing.T) {
+	var mu sync.Mutex
+	var lastConn net.Conn
+	s := startTestServer(t, func(s *tcpserver.Server) {
+		s.RegisterOnConnect(func(conn net.Conn) {
+			mu.Lock()
+			lastConn = conn
+			mu.Unlock()
+		})
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, "tcp", s.ListenAddr().String(), WithBackoff(10*time.Millisecond, 50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	if _, err := c.Call(ctx, string(codec.OpCbrt), 27); err != nil {
+		t.Fatalf("first Call: %v", err)
+	}
+
+	mu.Lock()
+	conn := lastConn
+	mu.Unlock()
+	if conn == nil {
+		t.Fatal("onConnect hook was never called")
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	var lastErr error
+	for time.Now().Before(deadline) {
+		if _, err := c.Call(ctx, string(codec.OpCbrt), 64); err == nil {
+			return
+		} else {
+			lastErr = err
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("Call never succeeded after forced disconnect, last error: %v", lastErr)
+}
+
+// TestClientPingWithKeepalive验证在客户端开启WithKeepalive后台心跳、服务端
+// 也周期性发送FramePing的同时调用Ping，每次Ping都能成功返回且RTT非负。
+// 它不检查某次Pong具体唤醒了哪一个等待者。
+func TestClientPingWithKeepalive(t *testing.T) {
+	s := startTestServer(t, func(s *tcpserver.Server) {
+		s.KeepalivePeriod = 20 * time.Millisecond
+		s.IdleTimeout = time.Second
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, "tcp", s.ListenAddr().String(), WithKeepalive(20*time.Millisecond, time.Second))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 5; i++ {
+		rtt, err := c.Ping(ctx)
+		if err != nil {
+			t.Fatalf("Ping(%d): %v", i, err)
+		}
+		if rtt < 0 {
+			t.Fatalf("Ping(%d) rtt = %v, want >= 0", i, rtt)
+		}
+		time.Sleep(15 * time.Millisecond)
+	}
+}
+
+// TestClientConcurrentPingsAllComplete并发调用多个Ping，验证每个调用方都
+// 能在超时前收到一个Pong并无错返回。每个FramePing都对应一个FramePong，
+// 因此即使Pong和等待者的配对顺序错乱也不会表现为失败；这里不检查配对顺序。
+func TestClientConcurrentPingsAllComplete(t *testing.T) {
+	s := startTestServer(t, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, "tcp", s.ListenAddr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	const n = 50
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			_, err := c.Ping(ctx)
+			errs[idx] = err
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("Ping(%d): %v", i, err)
+		}
+	}
+}
+
+// TestClientFailPendingDrainsPingWaiters验证failPending（连接断开、Close
+// 都会触发）会把pingWaiters和pending一起清空：旧连接上的Pong永远不会再
+// 到达，留在队列里的等待者必须被失败唤醒并移出队列，否则会在下一条连接
+// 上错配掉本该属于真正Ping的Pong。
+func TestClientFailPendingDrainsPingWaiters(t *testing.T) {
+	c := &Client{
+		pending: make(map[uint64]chan callResult),
+		closeCh: make(chan struct{}),
+	}
+	waitCh := make(chan error, 1)
+	c.enqueuePingWaiter(waitCh)
+	c.enqueuePingWaiter(nil) // heartbeatLoop发出的占位等待者，没有调用方在等
+
+	c.failPending(ErrClosed)
+
+	select {
+	case err := <-waitCh:
+		if err != ErrClosed {
+			t.Fatalf("waitCh err = %v, want ErrClosed", err)
+		}
+	default:
+		t.Fatal("failPending did not notify the pending ping waiter")
+	}
+
+	c.mu.Lock()
+	leftover := len(c.pingWaiters)
+	c.mu.Unlock()
+	if leftover != 0 {
+		t.Fatalf("pingWaiters has %d leftover entries after failPending, want 0", leftover)
+	}
+}
+
+// TestClientRemovePingWaiterOnEarlyReturn直接调用removePingWaiter（Ping()
+// 在写失败/ctx取消等提前返回时调用它），验证它只把指定的等待者从队列中
+// 摘除，而不会影响队列中其它等待者的FIFO顺序。
+func TestClientRemovePingWaiterOnEarlyReturn(t *testing.T) {
+	c := &Client{
+		pending: make(map[uint64]chan callResult),
+		closeCh: make(chan struct{}),
+	}
+	first := make(chan error, 1)
+	mine := make(chan error, 1)
+	last := make(chan error, 1)
+	c.enqueuePingWaiter(first)
+	c.enqueuePingWaiter(mine)
+	c.enqueuePingWaiter(last)
+
+	c.removePingWaiter(mine)
+
+	c.mu.Lock()
+	got := append([]chan error(nil), c.pingWaiters...)
+	c.mu.Unlock()
+	if len(got) != 2 || got[0] != first || got[1] != last {
+		t.Fatalf("pingWaiters = %v, want [first, last] with mine removed", got)
+	}
+}
@@ -0,0 +1,75 @@
+package tcpserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net"
+
+	"gopcp.v2/chapter3/socket/codec"
+)
+
+// CubeRootHandler 是内置的示例Handler，演示如何基于codec包的帧协议实现
+// 具体业务：不断从连接中读取请求帧，按请求中约定的运算符计算结果，
+// 并把响应帧写回连接，直到连接关闭或ctx被取消。
+type CubeRootHandler struct{}
+
+// Serve实现了Handler接口。
+func (CubeRootHandler) Serve(ctx context.Context, conn net.Conn) error {
+	encoder := codec.NewEncoder(conn)
+	decoder := codec.NewDecoder(conn)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		frameType, payload, err := decoder.ReadFrame()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if frameType == codec.FramePing {
+			if err := encoder.WriteFrame(codec.FramePong, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if frameType != codec.FrameRequest {
+			continue
+		}
+
+		req, err := codec.DecodeRequest(payload)
+		if err != nil {
+			continue
+		}
+
+		resp := compute(req)
+		body, err := codec.EncodeResponse(resp)
+		if err != nil {
+			return fmt.Errorf("tcpserver: encode response: %w", err)
+		}
+		if err := encoder.WriteFrame(codec.FrameResponse, body); err != nil {
+			return err
+		}
+	}
+}
+
+// compute按req.Op对req.Value执行对应的运算，生成响应消息。
+// 未知的Op会在ErrMsg中给出说明，而不是让调用方崩溃。
+func compute(req codec.Request) codec.Response {
+	switch req.Op {
+	case codec.OpCbrt:
+		return codec.Response{ID: req.ID, Result: math.Cbrt(float64(req.Value))}
+	case codec.OpSqrt:
+		return codec.Response{ID: req.ID, Result: math.Sqrt(float64(req.Value))}
+	case codec.OpLog:
+		return codec.Response{ID: req.ID, Result: math.Log(float64(req.Value))}
+	default:
+		return codec.Response{ID: req.ID, ErrMsg: fmt.Sprintf("unsupported op: %q", req.Op)}
+	}
+}
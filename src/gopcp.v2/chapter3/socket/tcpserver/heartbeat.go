@@ -0,0 +1,100 @@
+package tcpserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"gopcp.v2/chapter3/socket/codec"
+)
+
+// ErrIdleTimeout在连接超过IdleTimeout没有收到任何数据帧或心跳回应时返回，
+// 用来和普通的I/O错误区分开——它表示对端大概率已经失联，而不是网络抖动。
+var ErrIdleTimeout = errors.New("tcpserver: connection idle timeout")
+
+// trackingConn包装net.Conn，记录最近一次成功读取的时间(lastActivity)，
+// 使心跳goroutine无需额外的系统调用就能知道连接是否闲置。
+// 它的Write在writeMu保护下透传给底层连接，Encoder在其上的多次WriteFrame调用因为每次
+// 都会触发一次bufio.Writer.Flush（对应恰好一次底层Write），
+// 多个goroutine共用同一个trackingConn写帧时仍然是安全的。
+type trackingConn struct {
+	net.Conn
+
+	mu           sync.Mutex
+	lastActivity time.Time
+	writeMu      sync.Mutex
+	idleTimedOut bool
+}
+
+func newTrackingConn(conn net.Conn) *trackingConn {
+	return &trackingConn{Conn: conn, lastActivity: time.Now()}
+}
+
+func (c *trackingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err == nil {
+		c.touch()
+	}
+	return n, err
+}
+
+func (c *trackingConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.Conn.Write(p)
+}
+
+func (c *trackingConn) touch() {
+	c.mu.Lock()
+	c.lastActivity = time.Now()
+	c.mu.Unlock()
+}
+
+func (c *trackingConn) idleSince() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Since(c.lastActivity)
+}
+
+func (c *trackingConn) closeIdle() {
+	c.mu.Lock()
+	c.idleTimedOut = true
+	c.mu.Unlock()
+	c.Conn.Close()
+}
+
+func (c *trackingConn) timedOutIdle() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.idleTimedOut
+}
+
+// runHeartbeat在KeepalivePeriod非0时为一条连接启动心跳：每隔
+// KeepalivePeriod向对端发送一个FramePing帧；IdleTimeout非0时，还会在连接
+// 超过IdleTimeout没有任何成功的Read时，通过closeIdle主动断开连接。
+// 正常的请求/响应流量和对端的FramePong都会被trackingConn.Read记录为
+// 活动，从而重置闲置计时，不需要额外的系统调用。
+func (s *Server) runHeartbeat(ctx context.Context, conn *trackingConn, done <-chan struct{}) {
+	ticker := time.NewTicker(s.KeepalivePeriod)
+	defer ticker.Stop()
+	encoder := codec.NewEncoder(conn)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+			if s.IdleTimeout > 0 && conn.idleSince() > s.IdleTimeout {
+				conn.closeIdle()
+				return
+			}
+			// 发送失败通常意味着连接已经不可用，交由正常的读循环去
+			// 发现并关闭连接即可，这里不需要重复处理。
+			_ = encoder.WriteFrame(codec.FramePing, nil)
+		}
+	}
+}
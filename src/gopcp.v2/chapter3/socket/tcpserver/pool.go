@@ -0,0 +1,51 @@
+package tcpserver
+
+import (
+	"net"
+	"time"
+)
+
+// ConcurrencyMode 决定Server把已Accept的连接分派给goroutine处理的方式。
+type ConcurrencyMode int
+
+const (
+	// ModePerConn为每条连接启动一个独立的goroutine，是Server的默认模式，
+	// 编写简单，但连接数很大时会产生等量的goroutine。
+	ModePerConn ConcurrencyMode = iota
+
+	// ModePool把已Accept的连接交给固定数量的预启动worker goroutine处理，
+	// 用有界的goroutine数量换取对突发连接数的免疫力，代价是单个worker
+	// 在处理一条连接期间无法处理其他连接。
+	ModePool
+)
+
+// deadlineConn包装net.Conn，在每一次Read和Write之前都把对应方向的截止时间
+// （分别通过SetReadDeadline/SetWriteDeadline）重新设为now+timeout，使
+// RequestTimeout表达的是"一轮请求/响应的最长耗时"，而不是从连接交给worker
+// 那一刻起算的整条连接存活时间——否则一条长期存活、但每次请求都很快的连接，
+// 也会在RequestTimeout之后被错误地判定为超时并关闭，这正是worker池要避免的
+// "慢连接饿死其它连接"之外的另一种误杀。读、写截止时间必须分开设置：若改用
+// SetDeadline同时覆盖两个方向，心跳goroutine在同一条连接上周期性写出的
+// FramePing会把阻塞中的Read的截止时间一并重新延后，使RequestTimeout形同虚设。
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func newDeadlineConn(conn net.Conn, timeout time.Duration) *deadlineConn {
+	return &deadlineConn{Conn: conn, timeout: timeout}
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
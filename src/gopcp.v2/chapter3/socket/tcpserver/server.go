@@ -0,0 +1,307 @@
+/*
+Package tcpserver 提供了一个可复用的、反应堆风格的TCP服务端骨架，把连接
+接受、生命周期管理同具体的业务处理逻辑解耦开来：Server只负责监听、限流
+和优雅关闭，真正的业务逻辑由使用者实现的Handler接口完成。
+*/
+package tcpserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Handler 由使用者实现，负责处理一条已建立连接上的全部业务逻辑。
+// 当ctx被取消时，Serve应当尽快返回；返回的非nil error会被交给
+// RegisterOnError注册的钩子，若连接因闲置超时被关闭，则改为交出ErrIdleTimeout。
+type Handler interface {
+	Serve(ctx context.Context, conn net.Conn) error
+}
+
+// ConnHook 在连接建立或断开时被调用。
+type ConnHook func(conn net.Conn)
+
+// ErrHook 在Handler.Serve因错误返回时被调用，err可能是ErrIdleTimeout。
+type ErrHook func(conn net.Conn, err error)
+
+// Server 是一个可配置的TCP服务端。应使用NewServer创建，而不是直接使用
+// 其零值。
+type Server struct {
+	// Network和Addr传递给net.Listen，约定网络类型和监听地址。
+	Network string
+	Addr    string
+
+	// MaxConns限制同时处理的连接数量，为0表示不限制。达到上限后，
+	// Accept不会被调用，直到有连接释放出空闲名额，从而避免goroutine
+	// 在负载下无限增长。
+	MaxConns int
+
+	// Handler负责处理每一条已建立的连接。
+	Handler Handler
+
+	// Mode决定已Accept的连接如何被分派给goroutine处理，默认ModePerConn。
+	Mode ConcurrencyMode
+
+	// PoolSize是Mode为ModePool时预先启动的worker goroutine数量，
+	// 小于等于0时按1处理。
+	PoolSize int
+
+	// RequestTimeout非0时，ModePool下的连接会在每一次Read/Write之前重新
+	// 设置读写截止时间，即每一轮请求/响应都有最多RequestTimeout的时间，
+	// 而不是从连接交给worker那一刻起算，避免某一条连接的慢Handler占满
+	// worker、饿死池中其它待处理的连接，同时不会误杀持续产生快请求的
+	// 长连接。
+	RequestTimeout time.Duration
+
+	// KeepalivePeriod非0时，Server会为每条连接启动一个心跳goroutine，
+	// 每隔KeepalivePeriod发送一个codec.FramePing帧。
+	KeepalivePeriod time.Duration
+
+	// IdleTimeout是连接允许没有任何数据帧或心跳回应的最长时间，超过后
+	// 连接会被关闭并以ErrIdleTimeout结束。只有KeepalivePeriod非0时才生效。
+	IdleTimeout time.Duration
+
+	onConnect    ConnHook
+	onDisconnect ConnHook
+	onError      ErrHook
+
+	mu       sync.Mutex
+	listener net.Listener
+	cancel   context.CancelFunc
+	sem      chan struct{}
+	connCh   chan net.Conn
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+	closed   bool
+}
+
+// NewServer 创建一个监听network/addr、并把每条连接交给handler处理的Server。
+func NewServer(network, addr string, handler Handler) *Server {
+	return &Server{
+		Network: network,
+		Addr:    addr,
+		Handler: handler,
+		conns:   make(map[net.Conn]struct{}),
+	}
+}
+
+// RegisterOnConnect 注册一个在连接建立后立即触发的钩子。
+func (s *Server) RegisterOnConnect(hook ConnHook) {
+	s.onConnect = hook
+}
+
+// RegisterOnDisconnect 注册一个在连接断开后触发的钩子。
+func (s *Server) RegisterOnDisconnect(hook ConnHook) {
+	s.onDisconnect = hook
+}
+
+// RegisterOnError 注册一个在Handler.Serve返回非nil错误时触发的钩子，
+// err可能是ErrIdleTimeout。
+func (s *Server) RegisterOnError(hook ErrHook) {
+	s.onError = hook
+}
+
+// ListenAddr 返回当前正在监听的地址，只有在ListenAndServe已经开始监听之后
+// 才有效；主要用于测试中获取系统分配的实际端口（例如Addr配置为"127.0.0.1:0"）。
+func (s *Server) ListenAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// ListenAndServe 开始监听并接受连接，直到发生不可恢复的错误或者
+// Shutdown被调用。
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen(s.Network, s.Addr)
+	if err != nil {
+		return fmt.Errorf("tcpserver: listen error: %w", err)
+	}
+	defer listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		cancel()
+		return fmt.Errorf("tcpserver: server already shut down")
+	}
+	s.listener = listener
+	s.cancel = cancel
+	if s.MaxConns > 0 {
+		s.sem = make(chan struct{}, s.MaxConns)
+	}
+	if s.Mode == ModePool {
+		if s.PoolSize <= 0 {
+			s.PoolSize = 1
+		}
+		s.connCh = make(chan net.Conn)
+		for i := 0; i < s.PoolSize; i++ {
+			go s.poolWorker(ctx)
+		}
+	}
+	s.mu.Unlock()
+
+	defer func() {
+		if s.connCh != nil {
+			close(s.connCh)
+		}
+	}()
+
+	for {
+		if s.sem != nil {
+			select {
+			case s.sem <- struct{}{}:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if s.sem != nil {
+				<-s.sem
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+				return fmt.Errorf("tcpserver: accept error: %w", err)
+			}
+		}
+
+		s.wg.Add(1)
+		s.trackConn(conn, true)
+
+		if s.Mode == ModePool {
+			select {
+			case s.connCh <- conn:
+			case <-ctx.Done():
+				s.wg.Done()
+				s.trackConn(conn, false)
+				conn.Close()
+				if s.sem != nil {
+					<-s.sem
+				}
+				return nil
+			}
+			continue
+		}
+
+		go s.serveConn(ctx, conn)
+	}
+}
+
+// poolWorker是ModePool下的常驻worker goroutine，不断从connCh取出连接并
+// 同步处理，处理完一条才会取下一条，从而把并发goroutine数量固定在
+// PoolSize，不随连接数增长。
+func (s *Server) poolWorker(ctx context.Context) {
+	for conn := range s.connCh {
+		s.serveConn(ctx, conn)
+	}
+}
+
+func (s *Server) trackConn(conn net.Conn, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		s.conns[conn] = struct{}{}
+	} else {
+		delete(s.conns, conn)
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer s.wg.Done()
+	if s.sem != nil {
+		defer func() { <-s.sem }()
+	}
+	defer s.trackConn(conn, false)
+
+	var servedConn net.Conn = conn
+	if s.Mode == ModePool && s.RequestTimeout > 0 {
+		servedConn = newDeadlineConn(servedConn, s.RequestTimeout)
+	}
+
+	var tc *trackingConn
+	var heartbeatDone chan struct{}
+	if s.KeepalivePeriod > 0 {
+		tc = newTrackingConn(servedConn)
+		servedConn = tc
+		heartbeatDone = make(chan struct{})
+		go s.runHeartbeat(ctx, tc, heartbeatDone)
+	}
+	defer servedConn.Close()
+	if heartbeatDone != nil {
+		defer close(heartbeatDone)
+	}
+
+	if s.onConnect != nil {
+		s.onConnect(conn)
+	}
+	if s.onDisconnect != nil {
+		defer s.onDisconnect(conn)
+	}
+
+	err := s.Handler.Serve(ctx, servedConn)
+	if tc != nil && tc.timedOutIdle() {
+		err = ErrIdleTimeout
+	}
+	if err != nil && s.onError != nil {
+		s.onError(conn, err)
+	}
+}
+
+// Shutdown 停止接受新连接，通过context通知所有活跃的goroutine退出，
+// 并最多等待到ctx的截止时间；超时后仍未退出的连接会被强制关闭。
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	listener := s.listener
+	cancel := s.cancel
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	if listener != nil {
+		listener.Close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.closeRemaining()
+		return ctx.Err()
+	}
+}
+
+// closeRemaining强制关闭Shutdown截止时间到达时仍未退出的连接。
+func (s *Server) closeRemaining() {
+	s.mu.Lock()
+	remaining := make([]net.Conn, 0, len(s.conns))
+	for conn := range s.conns {
+		remaining = append(remaining, conn)
+	}
+	s.mu.Unlock()
+
+	for _, conn := range remaining {
+		conn.Close()
+	}
+}
@@ -0,0 +1,265 @@
+package tcpserver
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"gopcp.v2/chapter3/socket/codec"
+)
+
+// startTestServer启动一个监听在"127.0.0.1:0"上的Server，并在测试结束时
+// 通过Shutdown把它关闭。
+func startTestServer(t *testing.T, configure func(*Server)) *Server {
+	t.Helper()
+	s := NewServer("tcp", "127.0.0.1:0", CubeRootHandler{})
+	if configure != nil {
+		configure(s)
+	}
+
+	errCh := make(chan error, 1)
+	ready := make(chan struct{})
+	go func() {
+		err := s.ListenAndServe()
+		select {
+		case <-ready:
+		default:
+		}
+		errCh <- err
+	}()
+
+	// 等待ListenAddr就绪，轮询而不是sleep固定时间，避免偶发的启动延迟导致
+	// 测试在慢速CI上抖动。
+	deadline := time.Now().Add(time.Second)
+	for s.ListenAddr() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start listening in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(ready)
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+		<-errCh
+	})
+	return s
+}
+
+// cbrtOverConn通过codec在conn上发送一次OpCbrt请求并返回解码后的响应。
+func cbrtOverConn(t *testing.T, conn net.Conn, id uint64, value int32) codec.Response {
+	t.Helper()
+	enc := codec.NewEncoder(conn)
+	dec := codec.NewDecoder(conn)
+
+	body, err := codec.EncodeRequest(codec.Request{ID: id, Op: codec.OpCbrt, Value: value})
+	if err != nil {
+		t.Fatalf("EncodeRequest: %v", err)
+	}
+	if err := enc.WriteFrame(codec.FrameRequest, body); err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+
+	frameType, payload, err := dec.ReadFrame()
+	if err != nil {
+		t.Fatalf("ReadFrame: %v", err)
+	}
+	if frameType != codec.FrameResponse {
+		t.Fatalf("frameType = %d, want FrameResponse", frameType)
+	}
+	resp, err := codec.DecodeResponse(payload)
+	if err != nil {
+		t.Fatalf("DecodeResponse: %v", err)
+	}
+	return resp
+}
+
+func TestServerModePerConnBasicRequestResponse(t *testing.T) {
+	s := startTestServer(t, nil)
+
+	conn, err := net.Dial("tcp", s.ListenAddr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	resp := cbrtOverConn(t, conn, 1, 27)
+	if resp.Result != 3 {
+		t.Fatalf("Result = %v, want 3", resp.Result)
+	}
+}
+
+// TestServerModePoolRequestTimeoutIsPerRequest复现评审中描述的场景：
+// ModePool下配置一个较短的RequestTimeout，一条连接上持续发出间隔小于
+// RequestTimeout、但总耗时超过RequestTimeout的多轮快速请求，每一轮都应该
+// 成功——RequestTimeout描述的是单轮读写的超时，而不是连接自交给worker起
+// 算的总存活时间。
+func TestServerModePoolRequestTimeoutIsPerRequest(t *testing.T) {
+	s := startTestServer(t, func(s *Server) {
+		s.Mode = ModePool
+		s.PoolSize = 1
+		s.RequestTimeout = 150 * time.Millisecond
+	})
+
+	conn, err := net.Dial("tcp", s.ListenAddr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 6; i++ {
+		resp := cbrtOverConn(t, conn, uint64(i), 27)
+		if resp.Result != 3 {
+			t.Fatalf("call %d: Result = %v, want 3", i, resp.Result)
+		}
+		time.Sleep(80 * time.Millisecond)
+	}
+}
+
+// TestServerModePoolRequestTimeoutSurvivesKeepalive复现评审中描述的场景：
+// ModePool下同时配置RequestTimeout和KeepalivePeriod时，心跳goroutine周期性
+// 写出的FramePing不应该重新延后阻塞中的Read的截止时间——deadlineConn必须
+// 把读、写截止时间分开设置，否则一条从不发送任何字节的连接会被心跳写操作
+// 不断续命，永远等不到RequestTimeout生效。
+func TestServerModePoolRequestTimeoutSurvivesKeepalive(t *testing.T) {
+	s := startTestServer(t, func(s *Server) {
+		s.Mode = ModePool
+		s.PoolSize = 1
+		s.RequestTimeout = 100 * time.Millisecond
+		s.KeepalivePeriod = 20 * time.Millisecond
+	})
+
+	conn, err := net.Dial("tcp", s.ListenAddr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	// 不发送任何字节，只接收服务端心跳发来的FramePing，验证服务端会在
+	// RequestTimeout附近就把连接关闭，而不是被每一次FramePing写操作续命。
+	const slack = 200 * time.Millisecond
+	conn.SetReadDeadline(time.Now().Add(s.RequestTimeout + slack))
+	start := time.Now()
+	buf := make([]byte, 1)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			if elapsed := time.Since(start); elapsed > s.RequestTimeout+slack {
+				t.Fatalf("connection stayed open for %v, want close within RequestTimeout+slack (%v)", elapsed, s.RequestTimeout+slack)
+			}
+			return
+		}
+	}
+}
+
+func TestServerShutdownWaitsForInFlightThenClosesListener(t *testing.T) {
+	s := NewServer("tcp", "127.0.0.1:0", CubeRootHandler{})
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.ListenAndServe() }()
+
+	deadline := time.Now().Add(time.Second)
+	for s.ListenAddr() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start listening in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	conn, err := net.Dial("tcp", s.ListenAddr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	resp := cbrtOverConn(t, conn, 1, 8)
+	if resp.Result != 2 {
+		t.Fatalf("Result = %v, want 2", resp.Result)
+	}
+	// CubeRootHandler只在每轮ReadFrame之间检查ctx是否被取消，正在阻塞的
+	// 读调用不会被Shutdown打断；测试里主动关闭连接让Serve以EOF正常返回，
+	// 这样才能验证wg.Wait()在截止时间内完成这条"干净"路径，而不是触发
+	// closeRemaining的强制关闭分支。
+	conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("ListenAndServe returned %v, want nil", err)
+	}
+
+	if _, err := net.Dial("tcp", s.ListenAddr().String()); err == nil {
+		t.Fatal("Dial should fail after Shutdown")
+	}
+}
+
+// benchmarkServerMode对比ModePerConn和ModePool在大量短连接下的吞吐，呼应
+// 需求中"对比两种并发模型"的benchmark要求。
+func benchmarkServerMode(b *testing.B, mode ConcurrencyMode) {
+	s := NewServer("tcp", "127.0.0.1:0", CubeRootHandler{})
+	s.Mode = mode
+	if mode == ModePool {
+		s.PoolSize = 64
+	}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.ListenAndServe() }()
+
+	deadline := time.Now().Add(time.Second)
+	for s.ListenAddr() == nil {
+		if time.Now().After(deadline) {
+			b.Fatal("server did not start listening in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	addr := s.ListenAddr().String()
+
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for i := 0; i < b.N; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			defer conn.Close()
+
+			enc := codec.NewEncoder(conn)
+			dec := codec.NewDecoder(conn)
+			body, err := codec.EncodeRequest(codec.Request{ID: uint64(id), Op: codec.OpCbrt, Value: 27})
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			if err := enc.WriteFrame(codec.FrameRequest, body); err != nil {
+				b.Error(err)
+				return
+			}
+			if _, _, err := dec.ReadFrame(); err != nil {
+				b.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	b.StopTimer()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.Shutdown(ctx)
+	<-errCh
+}
+
+func BenchmarkServerModePerConn(b *testing.B) {
+	benchmarkServerMode(b, ModePerConn)
+}
+
+func BenchmarkServerModePool(b *testing.B) {
+	benchmarkServerMode(b, ModePool)
+}